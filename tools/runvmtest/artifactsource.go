@@ -0,0 +1,175 @@
+// Copyright 2023 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"dagger.io/dagger"
+)
+
+var artifactBackend = flag.String("artifact-backend", "dagger", "How to fetch kernel/qemu artifacts out of container images: dagger, podman, or local")
+
+// ArtifactSource fetches the files and directories named in a container
+// image and writes them into destDir, preserving their in-image paths (so
+// "/bzImage" becomes destDir+"/bzImage"). It lets runvmtest support
+// environments where a Dagger engine isn't available.
+type ArtifactSource interface {
+	Fetch(ctx context.Context, image string, files, dirs map[string]string, destDir string) error
+}
+
+// newArtifactSource builds the ArtifactSource selected by -artifact-backend.
+// client may be nil unless backend is "dagger".
+func newArtifactSource(backend string, client *dagger.Client) (ArtifactSource, error) {
+	switch backend {
+	case "dagger":
+		if client == nil {
+			return nil, fmt.Errorf("dagger artifact backend requires a dagger client")
+		}
+		return &daggerSource{client: client}, nil
+	case "podman":
+		return &podmanSource{}, nil
+	case "local":
+		return &localSource{}, nil
+	default:
+		return nil, fmt.Errorf("unknown artifact backend %q, want dagger, podman, or local", backend)
+	}
+}
+
+// daggerSource is the original, default backend: it uses a live Dagger
+// engine connection to pull and export container contents.
+type daggerSource struct {
+	client *dagger.Client
+}
+
+func (d *daggerSource) Fetch(ctx context.Context, image string, files, dirs map[string]string, destDir string) error {
+	base := d.client.Container()
+	for _, file := range files {
+		base = base.WithFile(file, d.client.Container().From(image).File(file))
+	}
+	for _, dir := range dirs {
+		base = base.WithDirectory(dir, d.client.Container().From(image).Directory(dir))
+	}
+	if ok, err := base.Directory("/").Export(ctx, destDir); !ok || err != nil {
+		return fmt.Errorf("failed artifact export: %w", err)
+	}
+	return nil
+}
+
+// podmanSource fetches artifacts with `podman pull` + `podman create` +
+// `podman cp`, for machines without a Dagger engine but with a container
+// runtime already available.
+type podmanSource struct{}
+
+func (p *podmanSource) Fetch(ctx context.Context, image string, files, dirs map[string]string, destDir string) error {
+	if err := runCmd(ctx, "podman", "pull", image); err != nil {
+		return fmt.Errorf("podman pull %s: %w", image, err)
+	}
+
+	out, err := exec.CommandContext(ctx, "podman", "create", image, "true").Output()
+	if err != nil {
+		return fmt.Errorf("podman create %s: %w", image, err)
+	}
+	container := strings.TrimSpace(string(out))
+	defer runCmd(context.Background(), "podman", "rm", container)
+
+	for _, path := range joinMaps(files, dirs) {
+		dest := filepath.Join(destDir, path)
+		if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+			return fmt.Errorf("mkdir %s: %w", dest, err)
+		}
+		if err := runCmd(ctx, "podman", "cp", container+":"+path, dest); err != nil {
+			return fmt.Errorf("podman cp %s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// localSource treats image as a path to a locally-mounted image tree (e.g.
+// an already-extracted container root or OSTree checkout) rather than a
+// registry reference, and simply copies the named files/directories out of
+// it. Useful for offline development and for CI runners that pre-stage
+// images on disk.
+type localSource struct{}
+
+func (l *localSource) Fetch(ctx context.Context, image string, files, dirs map[string]string, destDir string) error {
+	for _, path := range joinMaps(files, dirs) {
+		src := filepath.Join(image, path)
+		dest := filepath.Join(destDir, path)
+		if err := copyPath(src, dest); err != nil {
+			return fmt.Errorf("copying %s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+func joinMaps(a, b map[string]string) []string {
+	paths := make([]string, 0, len(a)+len(b))
+	for _, v := range a {
+		paths = append(paths, v)
+	}
+	for _, v := range b {
+		paths = append(paths, v)
+	}
+	return paths
+}
+
+func copyPath(src, dest string) error {
+	info, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return filepath.Walk(src, func(p string, fi os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(src, p)
+			if err != nil {
+				return err
+			}
+			target := filepath.Join(dest, rel)
+			if fi.IsDir() {
+				return os.MkdirAll(target, 0o755)
+			}
+			return copyFile(p, target, fi.Mode())
+		})
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+		return err
+	}
+	return copyFile(src, dest, info.Mode())
+}
+
+func copyFile(src, dest string, mode os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dest, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, mode)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	_, err = io.Copy(out, in)
+	return err
+}
+
+func runCmd(ctx context.Context, name string, args ...string) error {
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
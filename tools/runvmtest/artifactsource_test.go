@@ -0,0 +1,56 @@
+// Copyright 2023 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCopyPathFile(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "bzImage")
+	if err := os.WriteFile(src, []byte("kernel"), 0o644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	dest := filepath.Join(dir, "out", "bzImage")
+	if err := copyPath(src, dest); err != nil {
+		t.Fatalf("copyPath: %v", err)
+	}
+
+	got, err := os.ReadFile(dest)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	if string(got) != "kernel" {
+		t.Errorf("copied file contents = %q, want %q", got, "kernel")
+	}
+}
+
+func TestCopyPathDirectory(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "zqemu")
+	if err := os.MkdirAll(filepath.Join(src, "bin"), 0o755); err != nil {
+		t.Fatalf("MkdirAll: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(src, "bin", "qemu-system-x86_64"), []byte("qemu"), 0o755); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	dest := filepath.Join(dir, "out", "zqemu")
+	if err := copyPath(src, dest); err != nil {
+		t.Fatalf("copyPath: %v", err)
+	}
+
+	got, err := os.ReadFile(filepath.Join(dest, "bin", "qemu-system-x86_64"))
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	if string(got) != "qemu" {
+		t.Errorf("copied file contents = %q, want %q", got, "qemu")
+	}
+}
@@ -0,0 +1,82 @@
+// Copyright 2023 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/gob"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"time"
+)
+
+// runSubmit implements `runvmtest submit`: it serializes a Job and sends it
+// to a running daemon (see runDaemon), then streams the job's combined
+// stdout/stderr back to the local terminal until the daemon reports the job
+// finished, exiting with the job's exit code.
+func runSubmit(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("submit", flag.ExitOnError)
+	addr := fs.String("addr", defaultDaemonAddr, "address of the runvmtest daemon to submit to")
+	timeout := fs.Duration("timeout", 0, "abort and disconnect if the job doesn't finish within this duration (0 = no timeout)")
+	fs.Parse(args)
+	rest := fs.Args()
+
+	if len(rest) < 1 {
+		return fmt.Errorf("too few arguments: usage: `%s submit -- ./test-to-run`", os.Args[0])
+	}
+
+	var deadline time.Time
+	if *timeout > 0 {
+		deadline = time.Now().Add(*timeout)
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, deadline)
+		defer cancel()
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", *addr)
+	if err != nil {
+		return fmt.Errorf("unable to connect to daemon at %s: %w", *addr, err)
+	}
+	defer conn.Close()
+
+	if !deadline.IsZero() {
+		if err := conn.SetDeadline(deadline); err != nil {
+			return fmt.Errorf("unable to set connection deadline: %w", err)
+		}
+	}
+
+	job := Job{
+		GOARCH:    os.Getenv("VMTEST_ARCH"),
+		Args:      rest,
+		Env:       os.Environ(),
+		CreatedAt: time.Now(),
+	}
+	if err := gob.NewEncoder(conn).Encode(job); err != nil {
+		return fmt.Errorf("unable to submit job: %w", err)
+	}
+
+	dec := gob.NewDecoder(conn)
+	for {
+		var chunk LogChunk
+		if err := dec.Decode(&chunk); err != nil {
+			if ctx.Err() != nil {
+				return fmt.Errorf("timed out after %s waiting for job: %w", *timeout, ctx.Err())
+			}
+			return fmt.Errorf("lost connection to daemon: %w", err)
+		}
+		if len(chunk.Data) > 0 {
+			os.Stdout.Write(chunk.Data)
+		}
+		if chunk.Done {
+			if chunk.Status != JobSuccess {
+				os.Exit(chunk.ExitCode)
+			}
+			return nil
+		}
+	}
+}
@@ -0,0 +1,58 @@
+// Copyright 2023 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/BurntSushi/toml"
+)
+
+// loadArchTestConfig reads an ArchTestConfig override from a TOML file,
+// following the schema arch -> container -> env var -> {template, files,
+// directories}, matching the style of .out-of-tree.toml.
+func loadArchTestConfig(path string) (ArchTestConfig, error) {
+	var archConfig ArchTestConfig
+	if _, err := toml.DecodeFile(path, &archConfig); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	return archConfig, nil
+}
+
+// mergeArchTestConfig overlays file on top of defaults: any arch present in
+// file entirely replaces that arch's entry from defaults, CLI flags (applied
+// by their own callers) take precedence over both.
+func mergeArchTestConfig(defaults, file ArchTestConfig) ArchTestConfig {
+	merged := make(ArchTestConfig, len(defaults))
+	for arch, config := range defaults {
+		merged[arch] = config
+	}
+	for arch, config := range file {
+		merged[arch] = config
+	}
+	return merged
+}
+
+// runGenConfig implements `runvmtest gen-config`: it dumps the built-in
+// ArchTestConfig defaults as TOML, so downstream projects can start from a
+// working config and then pin kernel image tags, add QEMU flags, or register
+// additional env vars (initrd, DTB, ...) without forking runvmtest.
+func runGenConfig(args []string) error {
+	fs := flag.NewFlagSet("gen-config", flag.ExitOnError)
+	out := fs.String("out", "", "file to write the starter TOML to (default: stdout)")
+	fs.Parse(args)
+
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			return fmt.Errorf("unable to create %s: %w", *out, err)
+		}
+		defer f.Close()
+		return toml.NewEncoder(f).Encode(configs)
+	}
+	return toml.NewEncoder(os.Stdout).Encode(configs)
+}
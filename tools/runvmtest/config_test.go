@@ -0,0 +1,46 @@
+// Copyright 2023 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import "testing"
+
+func TestMergeArchTestConfig(t *testing.T) {
+	defaults := ArchTestConfig{
+		"amd64": {
+			"ghcr.io/hugelgupf/vmtest/kernel-amd64:main": map[string]EnvVar{
+				"VMTEST_KERNEL": {Template: "{{.Files.bzImage}}", Files: map[string]string{"bzImage": "/bzImage"}},
+			},
+		},
+		"arm64": {
+			"ghcr.io/hugelgupf/vmtest/kernel-arm64:main": map[string]EnvVar{
+				"VMTEST_KERNEL": {Template: "{{.Files.Image}}", Files: map[string]string{"Image": "/Image"}},
+			},
+		},
+	}
+	file := ArchTestConfig{
+		"amd64": {
+			"ghcr.io/hugelgupf/vmtest/kernel-amd64:v6.1": map[string]EnvVar{
+				"VMTEST_KERNEL": {Template: "{{.Files.bzImage}}", Files: map[string]string{"bzImage": "/bzImage"}},
+			},
+		},
+	}
+
+	merged := mergeArchTestConfig(defaults, file)
+
+	if _, ok := merged["amd64"]["ghcr.io/hugelgupf/vmtest/kernel-amd64:v6.1"]; !ok {
+		t.Error("merged config should contain the file's amd64 override")
+	}
+	if _, ok := merged["amd64"]["ghcr.io/hugelgupf/vmtest/kernel-amd64:main"]; ok {
+		t.Error("file's amd64 entry should entirely replace the default, not merge with it")
+	}
+	if _, ok := merged["arm64"]["ghcr.io/hugelgupf/vmtest/kernel-arm64:main"]; !ok {
+		t.Error("merged config should keep the default arm64 entry untouched by file")
+	}
+
+	// defaults must not be mutated by the merge.
+	if _, ok := defaults["amd64"]["ghcr.io/hugelgupf/vmtest/kernel-amd64:main"]; !ok {
+		t.Error("mergeArchTestConfig must not mutate its defaults argument")
+	}
+}
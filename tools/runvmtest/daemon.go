@@ -0,0 +1,389 @@
+// Copyright 2023 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/gob"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"dagger.io/dagger"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// defaultDaemonAddr is the address both `runvmtest daemon` and `runvmtest
+// submit` fall back to when -addr isn't given.
+const defaultDaemonAddr = "localhost:9271"
+
+// daemon is a long-running runvmtest server: it owns a single Dagger
+// connection, a SQLite-backed job queue, and an artifact cache shared by
+// every job it runs, so that many CI invocations don't each pay the cost of
+// reconnecting to Dagger and re-extracting the same container images.
+//
+// Accepted connections only enqueue a job; a bounded pool of workers
+// (-workers) pulls jobs off the jobs channel and runs them one at a time per
+// worker, so the queue actually provides backpressure instead of spawning an
+// unbounded number of concurrent QEMU invocations against one Dagger client.
+type daemon struct {
+	db     *sql.DB
+	client *dagger.Client
+
+	cacheDir string
+	jobs     chan *pendingJob
+
+	mu    sync.Mutex
+	cache map[string]string // image digest(s) -> extracted artifact dir
+}
+
+// pendingJob pairs a queued Job with the means to stream its output back to
+// the submitting connection and to signal handleConn when it's done.
+type pendingJob struct {
+	job  *Job
+	emit func(LogChunk)
+	done chan struct{}
+}
+
+func runDaemon(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("daemon", flag.ExitOnError)
+	addr := fs.String("addr", defaultDaemonAddr, "address to listen on")
+	db := fs.String("db", "runvmtest.db", "path to the SQLite job queue database")
+	cacheDir := fs.String("cache-dir", "runvmtest-cache", "directory used to cache extracted artifacts, keyed by image digest")
+	workers := fs.Int("workers", 1, "number of jobs to run concurrently against the shared Dagger connection")
+	fs.Parse(args)
+
+	sqldb, err := openQueue(*db)
+	if err != nil {
+		return fmt.Errorf("unable to open job queue: %w", err)
+	}
+	defer sqldb.Close()
+
+	client, err := dagger.Connect(ctx, dagger.WithLogOutput(os.Stdout))
+	if err != nil {
+		return fmt.Errorf("unable to connect to dagger: %w", err)
+	}
+	defer client.Close()
+
+	if err := os.MkdirAll(*cacheDir, 0o755); err != nil {
+		return fmt.Errorf("unable to create cache dir: %w", err)
+	}
+
+	d := &daemon{
+		db:       sqldb,
+		client:   client,
+		cacheDir: *cacheDir,
+		jobs:     make(chan *pendingJob),
+		cache:    make(map[string]string),
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < *workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			d.worker(ctx)
+		}()
+	}
+	defer wg.Wait()
+
+	ln, err := net.Listen("tcp", *addr)
+	if err != nil {
+		return fmt.Errorf("unable to listen on %s: %w", *addr, err)
+	}
+	defer ln.Close()
+	log.Printf("runvmtest daemon listening on %s (queue: %s, cache: %s, workers: %d)", *addr, *db, *cacheDir, *workers)
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			default:
+				log.Printf("accept: %v", err)
+				continue
+			}
+		}
+		go d.handleConn(ctx, conn)
+	}
+}
+
+func openQueue(path string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, err
+	}
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS jobs (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		goarch TEXT,
+		args TEXT,
+		status TEXT,
+		exit_code INTEGER,
+		created_at DATETIME,
+		started_at DATETIME,
+		ended_at DATETIME
+	)`)
+	if err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// handleConn decodes one job off the connection, enqueues it, and blocks
+// streaming its output back until a worker has run it to completion.
+func (d *daemon) handleConn(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+
+	var job Job
+	if err := gob.NewDecoder(conn).Decode(&job); err != nil {
+		log.Printf("decode job: %v", err)
+		return
+	}
+
+	id, err := d.enqueue(&job)
+	if err != nil {
+		log.Printf("enqueue job: %v", err)
+		return
+	}
+	job.ID = id
+	d.setWaiting(id)
+
+	enc := gob.NewEncoder(conn)
+	pj := &pendingJob{
+		job: &job,
+		emit: func(chunk LogChunk) {
+			if err := enc.Encode(chunk); err != nil {
+				log.Printf("job %d: stream to client: %v", job.ID, err)
+			}
+		},
+		done: make(chan struct{}),
+	}
+
+	select {
+	case d.jobs <- pj:
+	case <-ctx.Done():
+		return
+	}
+
+	select {
+	case <-pj.done:
+	case <-ctx.Done():
+	}
+}
+
+// worker pulls one job at a time off d.jobs and runs it to completion before
+// picking up the next one, bounding how many jobs this worker ever runs
+// concurrently to exactly one.
+func (d *daemon) worker(ctx context.Context) {
+	for {
+		select {
+		case pj := <-d.jobs:
+			d.setRunning(pj.job.ID)
+			d.run(ctx, pj.job, pj.emit)
+			close(pj.done)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (d *daemon) enqueue(job *Job) (int64, error) {
+	res, err := d.db.Exec(`INSERT INTO jobs (goarch, args, status, created_at) VALUES (?, ?, ?, ?)`,
+		job.GOARCH, fmt.Sprint(job.Args), JobNew, time.Now())
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+func (d *daemon) setWaiting(id int64) {
+	d.db.Exec(`UPDATE jobs SET status = ? WHERE id = ?`, JobWaiting, id)
+}
+
+func (d *daemon) setRunning(id int64) {
+	d.db.Exec(`UPDATE jobs SET status = ?, started_at = ? WHERE id = ?`, JobRunning, time.Now(), id)
+}
+
+func (d *daemon) setDone(id int64, status JobStatus, exitCode int) {
+	d.db.Exec(`UPDATE jobs SET status = ?, exit_code = ?, ended_at = ? WHERE id = ?`, status, exitCode, time.Now(), id)
+}
+
+// run extracts the job's artifacts (reusing the cache when the image digest
+// has already been fetched), execs the job's command with the resulting
+// VMTEST_KERNEL/VMTEST_QEMU env, and streams combined stdout/stderr to
+// emit via LogChunks.
+func (d *daemon) run(ctx context.Context, job *Job, emit func(LogChunk)) {
+	config := defaultConfig()
+	if c, ok := configs[job.GOARCH]; ok {
+		config = c
+	}
+	// Copy before mutating: config aliases the package-level configs map,
+	// and multiple workers may run jobs concurrently, so applying one job's
+	// overrides in place would race with and corrupt every other job's view
+	// of the defaults.
+	config = cloneTestEnvConfig(config)
+	for container, override := range job.ImageOverrides {
+		if envs, ok := config[container]; ok {
+			delete(config, container)
+			config[override] = envs
+		}
+	}
+
+	artifactDir, err := d.extractArtifacts(ctx, config)
+	if err != nil {
+		emit(LogChunk{JobID: job.ID, Data: []byte(err.Error() + "\n"), Done: true, Status: JobFailure, ExitCode: -1})
+		d.setDone(job.ID, JobFailure, -1)
+		return
+	}
+
+	envv, err := resolveEnv(config, artifactDir)
+	if err != nil {
+		emit(LogChunk{JobID: job.ID, Data: []byte(err.Error() + "\n"), Done: true, Status: JobFailure, ExitCode: -1})
+		d.setDone(job.ID, JobFailure, -1)
+		return
+	}
+
+	cmd := exec.CommandContext(ctx, job.Args[0], job.Args[1:]...)
+	cmd.Env = append(append(os.Environ(), job.Env...), envv...)
+	w := &streamWriter{jobID: job.ID, emit: emit}
+	cmd.Stdout = w
+	cmd.Stderr = w
+
+	// Each job gets its own log dir under the cache dir so guardedRun's
+	// serial.log tee doesn't collide across concurrently running jobs.
+	logDir := filepath.Join(d.cacheDir, fmt.Sprintf("job-%d", job.ID))
+	if err := os.MkdirAll(logDir, 0o755); err != nil {
+		emit(LogChunk{JobID: job.ID, Data: []byte(err.Error() + "\n"), Done: true, Status: JobFailure, ExitCode: -1})
+		d.setDone(job.ID, JobFailure, -1)
+		return
+	}
+
+	err = guardedRun(ctx, cmd, logDir, false)
+	status, code := JobSuccess, 0
+	if err != nil {
+		status, code = JobFailure, exitCodeOf(err)
+	}
+	emit(LogChunk{JobID: job.ID, Done: true, Status: status, ExitCode: code})
+	d.setDone(job.ID, status, code)
+}
+
+// extractArtifacts returns the directory containing extracted artifacts for
+// config, fetching and caching them by image digest if necessary.
+func (d *daemon) extractArtifacts(ctx context.Context, config TestEnvConfig) (string, error) {
+	key, err := d.digestKey(ctx, config)
+	if err != nil {
+		return "", fmt.Errorf("resolving image digests: %w", err)
+	}
+
+	d.mu.Lock()
+	if dir, ok := d.cache[key]; ok {
+		d.mu.Unlock()
+		return dir, nil
+	}
+	d.mu.Unlock()
+
+	dir, err := os.MkdirTemp(d.cacheDir, "artifacts-")
+	if err != nil {
+		return "", fmt.Errorf("unable to create artifact dir: %w", err)
+	}
+
+	base := d.client.Container()
+	for containerName, envs := range config {
+		for _, varConf := range envs {
+			for _, file := range varConf.Files {
+				base = base.WithFile(file, d.client.Container().From(containerName).File(file))
+			}
+			for _, dir := range varConf.Directories {
+				base = base.WithDirectory(dir, d.client.Container().From(containerName).Directory(dir))
+			}
+		}
+	}
+	if ok, err := base.Directory("/").Export(ctx, dir); !ok || err != nil {
+		return "", fmt.Errorf("failed artifact export: %w", err)
+	}
+
+	d.mu.Lock()
+	d.cache[key] = dir
+	d.mu.Unlock()
+	return dir, nil
+}
+
+// digestKey resolves the image digest of every container referenced by
+// config and joins them into a stable cache key, so the cache is keyed by
+// what's actually in the image rather than by a mutable tag -- a daemon
+// tracking a ":main" tag picks up upstream changes instead of caching them
+// away forever.
+func (d *daemon) digestKey(ctx context.Context, config TestEnvConfig) (string, error) {
+	containers := make([]string, 0, len(config))
+	for container := range config {
+		containers = append(containers, container)
+	}
+	return joinDigests(containers, func(container string) (string, error) {
+		return d.client.Container().From(container).ImageRef(ctx)
+	})
+}
+
+// joinDigests resolves each container in containers via lookup and joins the
+// results into a single stable cache key, independent of map iteration
+// order. Split out from digestKey so the joining/sorting logic can be unit
+// tested without a live Dagger client.
+func joinDigests(containers []string, lookup func(string) (string, error)) (string, error) {
+	sorted := append([]string(nil), containers...)
+	sort.Strings(sorted)
+
+	digests := make([]string, 0, len(sorted))
+	for _, container := range sorted {
+		ref, err := lookup(container)
+		if err != nil {
+			return "", fmt.Errorf("resolving digest for %s: %w", container, err)
+		}
+		digests = append(digests, ref)
+	}
+	return strings.Join(digests, ","), nil
+}
+
+// cloneTestEnvConfig shallow-copies the outer container->envs map so callers
+// can add/remove container entries without mutating the TestEnvConfig they
+// copied it from.
+func cloneTestEnvConfig(config TestEnvConfig) TestEnvConfig {
+	cloned := make(TestEnvConfig, len(config))
+	for container, envs := range config {
+		cloned[container] = envs
+	}
+	return cloned
+}
+
+func exitCodeOf(err error) int {
+	if err == nil {
+		return 0
+	}
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		return exitErr.ExitCode()
+	}
+	return -1
+}
+
+// streamWriter turns Write calls into LogChunks emitted to a client.
+type streamWriter struct {
+	jobID int64
+	emit  func(LogChunk)
+}
+
+func (w *streamWriter) Write(p []byte) (int, error) {
+	data := make([]byte, len(p))
+	copy(data, p)
+	w.emit(LogChunk{JobID: w.jobID, Data: data})
+	return len(p), nil
+}
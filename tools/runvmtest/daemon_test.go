@@ -0,0 +1,143 @@
+// Copyright 2023 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"testing"
+)
+
+func TestCloneTestEnvConfigDoesNotAliasOriginal(t *testing.T) {
+	original := TestEnvConfig{
+		"ghcr.io/hugelgupf/vmtest/kernel-amd64:main": map[string]EnvVar{
+			"VMTEST_KERNEL": {Template: "{{.Files.bzImage}}", Files: map[string]string{"bzImage": "/bzImage"}},
+		},
+	}
+
+	cloned := cloneTestEnvConfig(original)
+	cloned["ghcr.io/hugelgupf/vmtest/kernel-amd64:v6.1"] = cloned["ghcr.io/hugelgupf/vmtest/kernel-amd64:main"]
+	delete(cloned, "ghcr.io/hugelgupf/vmtest/kernel-amd64:main")
+
+	if _, ok := original["ghcr.io/hugelgupf/vmtest/kernel-amd64:main"]; !ok {
+		t.Error("mutating the clone deleted an entry from the original config")
+	}
+	if _, ok := original["ghcr.io/hugelgupf/vmtest/kernel-amd64:v6.1"]; ok {
+		t.Error("mutating the clone added an entry to the original config")
+	}
+}
+
+func TestJoinDigests(t *testing.T) {
+	lookups := map[string]string{
+		"b-container": "sha256:bbb",
+		"a-container": "sha256:aaa",
+	}
+	lookup := func(container string) (string, error) {
+		return lookups[container], nil
+	}
+
+	// Deliberately out of sorted order, to confirm the key doesn't depend
+	// on map iteration order.
+	key, err := joinDigests([]string{"b-container", "a-container"}, lookup)
+	if err != nil {
+		t.Fatalf("joinDigests: %v", err)
+	}
+	want := "sha256:aaa,sha256:bbb"
+	if key != want {
+		t.Errorf("joinDigests = %q, want %q", key, want)
+	}
+
+	key2, err := joinDigests([]string{"a-container", "b-container"}, lookup)
+	if err != nil {
+		t.Fatalf("joinDigests: %v", err)
+	}
+	if key != key2 {
+		t.Errorf("joinDigests is sensitive to input order: %q != %q", key, key2)
+	}
+}
+
+func TestJoinDigestsPropagatesLookupError(t *testing.T) {
+	_, err := joinDigests([]string{"broken"}, func(string) (string, error) {
+		return "", fmt.Errorf("boom")
+	})
+	if err == nil {
+		t.Error("expected an error when lookup fails")
+	}
+}
+
+func TestExitCodeOf(t *testing.T) {
+	if code := exitCodeOf(nil); code != 0 {
+		t.Errorf("exitCodeOf(nil) = %d, want 0", code)
+	}
+
+	if code := exitCodeOf(fmt.Errorf("not an exec error")); code != -1 {
+		t.Errorf("exitCodeOf(generic error) = %d, want -1", code)
+	}
+
+	err := exec.Command("sh", "-c", "exit 3").Run()
+	if code := exitCodeOf(err); code != 3 {
+		t.Errorf("exitCodeOf(exit 3) = %d, want 3", code)
+	}
+}
+
+func TestEnqueueAndStatusTransitions(t *testing.T) {
+	db, err := openQueue(":memory:")
+	if err != nil {
+		t.Fatalf("openQueue: %v", err)
+	}
+	defer db.Close()
+
+	d := &daemon{db: db}
+	id, err := d.enqueue(&Job{GOARCH: "amd64", Args: []string{"go", "test", "./..."}})
+	if err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+
+	assertJobField := func(column string, want any) {
+		t.Helper()
+		row := db.QueryRow(fmt.Sprintf(`SELECT %s FROM jobs WHERE id = ?`, column), id)
+		var got any
+		if err := row.Scan(&got); err != nil {
+			t.Fatalf("scanning %s: %v", column, err)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("%s = %v, want %v", column, got, want)
+		}
+	}
+
+	assertJobField("status", JobNew)
+
+	var createdAt any
+	if err := db.QueryRow(`SELECT created_at FROM jobs WHERE id = ?`, id).Scan(&createdAt); err != nil {
+		t.Fatalf("scanning created_at: %v", err)
+	}
+	if createdAt == nil {
+		t.Error("created_at was not populated by enqueue")
+	}
+
+	d.setWaiting(id)
+	assertJobField("status", JobWaiting)
+
+	d.setRunning(id)
+	assertJobField("status", JobRunning)
+	var startedAt any
+	if err := db.QueryRow(`SELECT started_at FROM jobs WHERE id = ?`, id).Scan(&startedAt); err != nil {
+		t.Fatalf("scanning started_at: %v", err)
+	}
+	if startedAt == nil {
+		t.Error("started_at was not populated by setRunning")
+	}
+
+	d.setDone(id, JobSuccess, 0)
+	assertJobField("status", JobSuccess)
+	assertJobField("exit_code", 0)
+	var endedAt any
+	if err := db.QueryRow(`SELECT ended_at FROM jobs WHERE id = ?`, id).Scan(&endedAt); err != nil {
+		t.Fatalf("scanning ended_at: %v", err)
+	}
+	if endedAt == nil {
+		t.Error("ended_at was not populated by setDone")
+	}
+}
@@ -0,0 +1,51 @@
+// Copyright 2023 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import "time"
+
+// JobStatus is the lifecycle state of a Job in the daemon's queue.
+type JobStatus string
+
+const (
+	JobNew     JobStatus = "new"
+	JobWaiting JobStatus = "waiting"
+	JobRunning JobStatus = "running"
+	JobSuccess JobStatus = "success"
+	JobFailure JobStatus = "failure"
+)
+
+// Job describes a single test invocation submitted to the daemon.
+//
+// It is serialized with encoding/gob between client and daemon.
+type Job struct {
+	ID int64
+
+	GOARCH string
+	// Image overrides container name -> replacement image reference, so a
+	// client can pin a different kernel/qemu image than the daemon's
+	// built-in defaults without changing the daemon's config.
+	ImageOverrides map[string]string
+
+	Args []string
+	Env  []string
+
+	Status    JobStatus
+	ExitCode  int
+	CreatedAt time.Time
+	StartedAt time.Time
+	EndedAt   time.Time
+}
+
+// LogChunk is a streamed slice of a running job's combined stdout/stderr.
+type LogChunk struct {
+	JobID int64
+	Data  []byte
+	// Done is set on the final LogChunk sent for a job; that chunk's
+	// Status and ExitCode hold the job's final status and exit code.
+	Done     bool
+	Status   JobStatus
+	ExitCode int
+}
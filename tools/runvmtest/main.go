@@ -25,10 +25,36 @@ import (
 
 var (
 	keepArtifacts = flag.Bool("keep-artifacts", false, "Keep artifacts directory available for further local tests")
+	configPath    = flag.String("config", "", "Path to a TOML file overriding the built-in ArchTestConfig defaults (see `runvmtest gen-config`)")
 )
 
 func main() {
-	if err := run(); err != nil {
+	ctx := context.Background()
+
+	// Subcommands that don't exec a test command directly, e.g. `runvmtest
+	// daemon` and `runvmtest submit`, are dispatched on the first
+	// command-line argument before the rest of the flags are parsed.
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "daemon":
+			if err := runDaemon(ctx, os.Args[2:]); err != nil {
+				log.Fatalf("Error: %v", err)
+			}
+			return
+		case "submit":
+			if err := runSubmit(ctx, os.Args[2:]); err != nil {
+				log.Fatalf("Error: %v", err)
+			}
+			return
+		case "gen-config":
+			if err := runGenConfig(os.Args[2:]); err != nil {
+				log.Fatalf("Error: %v", err)
+			}
+			return
+		}
+	}
+
+	if err := run(ctx); err != nil {
 		log.Fatalf("Error: %v", err)
 	}
 }
@@ -45,13 +71,13 @@ type EnvVar struct {
 	// {{.Files.$name}} can be used to refer to files extracted from the
 	// container, where $name is the key to one of the Files / Directories
 	// maps.
-	Template string
+	Template string `toml:"template"`
 
 	// Map of template variable name -> path in container
-	Files map[string]string
+	Files map[string]string `toml:"files"`
 
 	// Map of template variable name -> path in container
-	Directories map[string]string
+	Directories map[string]string `toml:"directories"`
 }
 
 var configs = ArchTestConfig{
@@ -100,19 +126,24 @@ var configs = ArchTestConfig{
 }
 
 func defaultConfig() TestEnvConfig {
+	return selectConfig(configs)
+}
+
+// selectConfig picks the TestEnvConfig for the current arch (VMTEST_ARCH, or
+// else runtime.GOARCH) out of archConfig.
+func selectConfig(archConfig ArchTestConfig) TestEnvConfig {
 	arch := os.Getenv("VMTEST_ARCH")
-	if c, ok := configs[arch]; ok {
+	if c, ok := archConfig[arch]; ok {
 		return c
 	}
-	if c, ok := configs[runtime.GOARCH]; ok {
+	if c, ok := archConfig[runtime.GOARCH]; ok {
 		return c
 	}
 	// On other architectures, user has to provide all values via flags.
 	return TestEnvConfig{}
 }
 
-func run() error {
-	config := defaultConfig()
+func run(ctx context.Context) error {
 	//config.RegisterFlags(flag.CommandLine)
 	flag.Parse()
 
@@ -120,17 +151,76 @@ func run() error {
 		return fmt.Errorf("too few arguments: usage: `%s -- ./test-to-run`", os.Args[0])
 	}
 
-	ctx := context.Background()
-	client, err := dagger.Connect(ctx, dagger.WithLogOutput(os.Stdout))
+	archConfig := configs
+	if *configPath != "" {
+		fileConfig, err := loadArchTestConfig(*configPath)
+		if err != nil {
+			return fmt.Errorf("unable to load -config: %w", err)
+		}
+		archConfig = mergeArchTestConfig(configs, fileConfig)
+	}
+	config := selectConfig(archConfig)
+
+	var client *dagger.Client
+	if *artifactBackend == "dagger" {
+		c, err := dagger.Connect(ctx, dagger.WithLogOutput(os.Stdout))
+		if err != nil {
+			return fmt.Errorf("unable to connect to client: %w", err)
+		}
+		defer c.Close()
+		client = c
+	}
+
+	source, err := newArtifactSource(*artifactBackend, client)
 	if err != nil {
-		return fmt.Errorf("unable to connect to client: %w", err)
+		return err
 	}
-	defer client.Close()
 
-	return runNatively(ctx, client, config, flag.Args())
+	if *matrixImages != "" {
+		return runMatrix(ctx, source, config, flag.Args())
+	}
+	return runNatively(ctx, source, config, flag.Args())
+}
+
+// resolveEnv evaluates config's env var templates against files/directories
+// already extracted into artifactDir, returning "NAME=value" pairs for the
+// ones not already set in the environment.
+func resolveEnv(config TestEnvConfig, artifactDir string) ([]string, error) {
+	var envv []string
+	for _, envs := range config {
+		for varName, varConf := range envs {
+			// Already set by caller.
+			if os.Getenv(varName) != "" {
+				continue
+			}
+
+			files := struct {
+				Files map[string]string
+			}{
+				Files: make(map[string]string),
+			}
+			for templateName, file := range varConf.Files {
+				files.Files[templateName] = filepath.Join(artifactDir, file)
+			}
+			for templateName, dir := range varConf.Directories {
+				files.Files[templateName] = filepath.Join(artifactDir, dir)
+			}
+
+			tmpl, err := template.New("var-" + varName).Parse(varConf.Template)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s template: %w", varName, err)
+			}
+			var s strings.Builder
+			if err := tmpl.Execute(&s, files); err != nil {
+				return nil, fmt.Errorf("failed to substitute %s template variables: %w", varName, err)
+			}
+			envv = append(envv, varName+"="+s.String())
+		}
+	}
+	return envv, nil
 }
 
-func runNatively(ctx context.Context, client *dagger.Client, config TestEnvConfig, args []string) error {
+func runNatively(ctx context.Context, source ArtifactSource, config TestEnvConfig, args []string) error {
 	var tmpDir string
 
 	if !*keepArtifacts {
@@ -172,44 +262,17 @@ func runNatively(ctx context.Context, client *dagger.Client, config TestEnvConfi
 		return fmt.Errorf("could not retrieve absolute path: %w", err)
 	}
 
-	base := client.Container()
-	var envv []string
 	for containerName, envs := range config {
-		for varName, varConf := range envs {
-			// Already set by caller.
-			if os.Getenv(varName) != "" {
-				continue
+		for _, varConf := range envs {
+			if err := source.Fetch(ctx, containerName, varConf.Files, varConf.Directories, tmpDir); err != nil {
+				return fmt.Errorf("failed artifact fetch: %w", err)
 			}
-
-			files := struct {
-				Files map[string]string
-			}{
-				Files: make(map[string]string),
-			}
-			for templateName, file := range varConf.Files {
-				base = base.WithFile(file, client.Container().From(containerName).File(file))
-				files.Files[templateName] = filepath.Join(tmp, file)
-			}
-			for templateName, dir := range varConf.Directories {
-				base = base.WithDirectory(dir, client.Container().From(containerName).Directory(dir))
-				files.Files[templateName] = filepath.Join(tmp, dir)
-			}
-
-			tmpl, err := template.New("var-" + varName).Parse(varConf.Template)
-			if err != nil {
-				return fmt.Errorf("invalid %s template: %w", varName, err)
-			}
-			var s strings.Builder
-			if err := tmpl.Execute(&s, files); err != nil {
-				return fmt.Errorf("failed to substitute %s template variables: %w", varName, err)
-			}
-			envv = append(envv, varName+"="+s.String())
 		}
 	}
-	artifacts := base.Directory("/")
 
-	if ok, err := artifacts.Export(ctx, tmpDir); !ok || err != nil {
-		return fmt.Errorf("failed artifact export: %w", err)
+	envv, err := resolveEnv(config, tmp)
+	if err != nil {
+		return err
 	}
 
 	cmd := exec.Command(args[0], args[1:]...)
@@ -226,7 +289,7 @@ func runNatively(ctx context.Context, client *dagger.Client, config TestEnvConfi
 		}()
 	}
 
-	if err := cmd.Run(); err != nil {
+	if err := guardedRun(ctx, cmd, tmpDir, *keepArtifacts); err != nil {
 		return fmt.Errorf("failed execution: %w", err)
 	}
 	return nil
@@ -0,0 +1,83 @@
+// Copyright 2023 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResolveEnv(t *testing.T) {
+	config := TestEnvConfig{
+		"ghcr.io/hugelgupf/vmtest/kernel-amd64:main": map[string]EnvVar{
+			"VMTEST_KERNEL": {
+				Template: "{{.Files.bzImage}}",
+				Files:    map[string]string{"bzImage": "/bzImage"},
+			},
+		},
+		"ghcr.io/hugelgupf/vmtest/qemu:main": map[string]EnvVar{
+			"VMTEST_QEMU": {
+				Template:    "{{.Files.qemu}}/bin/qemu-system-x86_64",
+				Directories: map[string]string{"qemu": "/zqemu"},
+			},
+		},
+	}
+
+	envv, err := resolveEnv(config, "/artifacts")
+	if err != nil {
+		t.Fatalf("resolveEnv: %v", err)
+	}
+
+	want := map[string]string{
+		"VMTEST_KERNEL": filepath.Join("/artifacts", "bzImage"),
+		"VMTEST_QEMU":   filepath.Join("/artifacts", "zqemu") + "/bin/qemu-system-x86_64",
+	}
+	got := make(map[string]string, len(envv))
+	for _, kv := range envv {
+		name, value, ok := splitEnv(kv)
+		if !ok {
+			t.Fatalf("malformed env entry %q", kv)
+		}
+		got[name] = value
+	}
+
+	for name, value := range want {
+		if got[name] != value {
+			t.Errorf("env %s = %q, want %q", name, got[name], value)
+		}
+	}
+}
+
+func TestResolveEnvSkipsAlreadySet(t *testing.T) {
+	os.Setenv("VMTEST_KERNEL", "/already/set")
+	defer os.Unsetenv("VMTEST_KERNEL")
+
+	config := TestEnvConfig{
+		"ghcr.io/hugelgupf/vmtest/kernel-amd64:main": map[string]EnvVar{
+			"VMTEST_KERNEL": {
+				Template: "{{.Files.bzImage}}",
+				Files:    map[string]string{"bzImage": "/bzImage"},
+			},
+		},
+	}
+
+	envv, err := resolveEnv(config, "/artifacts")
+	if err != nil {
+		t.Fatalf("resolveEnv: %v", err)
+	}
+	if len(envv) != 0 {
+		t.Errorf("resolveEnv returned %v, want no entries for an already-set var", envv)
+	}
+}
+
+func splitEnv(kv string) (name, value string, ok bool) {
+	for i := 0; i < len(kv); i++ {
+		if kv[i] == '=' {
+			return kv[:i], kv[i+1:], true
+		}
+	}
+	return "", "", false
+}
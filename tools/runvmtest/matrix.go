@@ -0,0 +1,182 @@
+// Copyright 2023 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/remeh/sizedwaitgroup"
+)
+
+var (
+	matrixImages    = flag.String("kernel-image", "", "Comma-separated list of container image references to run the test matrix against, e.g. ghcr.io/hugelgupf/vmtest/kernel-amd64:v5.10,ghcr.io/hugelgupf/vmtest/kernel-amd64:v6.1 (replaces the default kernel container for the current GOARCH)")
+	matrixRuns      = flag.Int("runs", 1, "Number of times to run the test command against each matrix entry")
+	matrixThreads   = flag.Int("threads", 1, "Number of matrix invocations to run concurrently")
+	matrixThreshold = flag.Float64("threshold", 1.0, "Minimum fraction of invocations that must succeed for runvmtest to exit 0")
+)
+
+// matrixResult is the outcome of a single (image, run) invocation.
+type matrixResult struct {
+	image   string
+	run     int
+	success bool
+	err     error
+}
+
+// runMatrix runs args against every image in *matrixImages, *matrixRuns
+// times each, *matrixThreads at a time, and exits nonzero if the fraction
+// of successful invocations falls below *matrixThreshold. It fetches
+// artifacts through source, so it works with any -artifact-backend.
+func runMatrix(ctx context.Context, source ArtifactSource, config TestEnvConfig, args []string) error {
+	images := strings.Split(*matrixImages, ",")
+
+	kernelContainer, err := singleKernelContainer(config)
+	if err != nil {
+		return err
+	}
+
+	// Extract each matrix entry's artifacts once, into its own tmp subdir,
+	// so different images don't overwrite each other's extracted files. All
+	// runs of one image share that image's subdir.
+	artifactDirs := make(map[string]string, len(images))
+	for _, image := range images {
+		dir, err := extractForImage(ctx, source, config, kernelContainer, image)
+		if err != nil {
+			return fmt.Errorf("extracting artifacts for %s: %w", image, err)
+		}
+		artifactDirs[image] = dir
+		if !*keepArtifacts {
+			defer os.RemoveAll(dir)
+		}
+	}
+
+	swg := sizedwaitgroup.New(*matrixThreads)
+	results := make(chan matrixResult, len(images)*(*matrixRuns))
+	for _, image := range images {
+		for run := 0; run < *matrixRuns; run++ {
+			swg.Add()
+			go func(image string, run int) {
+				defer swg.Done()
+				results <- invokeMatrixEntry(ctx, config, artifactDirs[image], image, run, args)
+			}(image, run)
+		}
+	}
+	swg.Wait()
+	close(results)
+
+	var all []matrixResult
+	for r := range results {
+		all = append(all, r)
+	}
+	return summarizeMatrix(all)
+}
+
+// singleKernelContainer returns the sole container name in config whose env
+// vars reference a Files entry -- i.e. the kernel image -- since that's the
+// one --kernel-image substitutes. Other containers (e.g. the qemu image) are
+// left as-is across the whole matrix.
+func singleKernelContainer(config TestEnvConfig) (string, error) {
+	var found string
+	for container, envs := range config {
+		for _, varConf := range envs {
+			if len(varConf.Files) > 0 {
+				if found != "" && found != container {
+					return "", fmt.Errorf("multiple kernel containers found in config, don't know which to substitute for --kernel-image")
+				}
+				found = container
+			}
+		}
+	}
+	if found == "" {
+		return "", fmt.Errorf("no kernel container found in config to substitute for --kernel-image")
+	}
+	return found, nil
+}
+
+func extractForImage(ctx context.Context, source ArtifactSource, config TestEnvConfig, kernelContainer, image string) (string, error) {
+	dir, err := os.MkdirTemp(".", "ci-testing-matrix")
+	if err != nil {
+		return "", fmt.Errorf("unable to create tmp dir: %w", err)
+	}
+
+	for container, envs := range config {
+		if container == kernelContainer {
+			container = image
+		}
+		for _, varConf := range envs {
+			if err := source.Fetch(ctx, container, varConf.Files, varConf.Directories, dir); err != nil {
+				return "", fmt.Errorf("failed artifact fetch: %w", err)
+			}
+		}
+	}
+	return dir, nil
+}
+
+func invokeMatrixEntry(ctx context.Context, config TestEnvConfig, artifactDir, image string, run int, args []string) matrixResult {
+	envv, err := resolveEnv(config, artifactDir)
+	if err != nil {
+		return matrixResult{image: image, run: run, err: err}
+	}
+
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Env = append(os.Environ(), envv...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	// Each run gets its own log dir so concurrent runs against the same
+	// image's artifactDir don't clobber each other's serial.log.
+	logDir := filepath.Join(artifactDir, "logs", fmt.Sprintf("run-%d", run))
+	if err := os.MkdirAll(logDir, 0o755); err != nil {
+		return matrixResult{image: image, run: run, err: err}
+	}
+
+	if err := guardedRun(ctx, cmd, logDir, *keepArtifacts); err != nil {
+		return matrixResult{image: image, run: run, err: err}
+	}
+	return matrixResult{image: image, run: run, success: true}
+}
+
+func summarizeMatrix(results []matrixResult) error {
+	if len(results) == 0 {
+		return fmt.Errorf("no matrix invocations ran (check --kernel-image and --runs)")
+	}
+
+	perImage := make(map[string][2]int) // image -> [success, total]
+	var successes int
+	for _, r := range results {
+		counts := perImage[r.image]
+		counts[1]++
+		if r.success {
+			counts[0]++
+			successes++
+		}
+		perImage[r.image] = counts
+	}
+
+	fmt.Println("\nMatrix summary:")
+	for image, counts := range perImage {
+		fmt.Printf("  %-60s %d/%d passed\n", image, counts[0], counts[1])
+	}
+
+	rate := float64(successes) / float64(len(results))
+	fmt.Printf("\nOverall: %d/%d passed (%s), threshold %s\n",
+		successes, len(results), formatRate(rate), formatRate(*matrixThreshold))
+
+	if rate < *matrixThreshold {
+		return fmt.Errorf("success rate %s below threshold %s", formatRate(rate), formatRate(*matrixThreshold))
+	}
+	return nil
+}
+
+func formatRate(f float64) string {
+	return strconv.FormatFloat(f*100, 'f', 1, 64) + "%"
+}
@@ -0,0 +1,46 @@
+// Copyright 2023 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import "testing"
+
+func TestFormatRate(t *testing.T) {
+	cases := map[float64]string{
+		1.0:  "100.0%",
+		0.9:  "90.0%",
+		0.0:  "0.0%",
+		0.75: "75.0%",
+	}
+	for rate, want := range cases {
+		if got := formatRate(rate); got != want {
+			t.Errorf("formatRate(%v) = %q, want %q", rate, got, want)
+		}
+	}
+}
+
+func TestSummarizeMatrix(t *testing.T) {
+	*matrixThreshold = 0.5
+
+	results := []matrixResult{
+		{image: "a", run: 0, success: true},
+		{image: "a", run: 1, success: false},
+		{image: "b", run: 0, success: true},
+		{image: "b", run: 1, success: true},
+	}
+	if err := summarizeMatrix(results); err != nil {
+		t.Errorf("summarizeMatrix with 75%% success and 50%% threshold: %v", err)
+	}
+
+	*matrixThreshold = 0.9
+	if err := summarizeMatrix(results); err == nil {
+		t.Error("summarizeMatrix with 75% success and 90% threshold should fail")
+	}
+}
+
+func TestSummarizeMatrixNoResults(t *testing.T) {
+	if err := summarizeMatrix(nil); err == nil {
+		t.Error("summarizeMatrix with no results should fail instead of reporting a silent 0/0 success")
+	}
+}
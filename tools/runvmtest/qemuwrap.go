@@ -0,0 +1,189 @@
+// Copyright 2023 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var (
+	qemuTimeout       = flag.Duration("qemu-timeout", 0, "Kill the test command if it runs longer than this (0 = no timeout)")
+	afterStartTimeout = flag.Duration("after-start-timeout", 0, "Kill the test command if it produces no output for this long after starting (0 = no timeout)")
+	panicDetect       = flag.Bool("panic-detect", false, "Scan the test command's output for kernel panics/oops/KASAN reports and kill it on the first match")
+)
+
+const (
+	// Codes identifying which guardrail killed the test command; guardedRun
+	// reports the matching exitReason name in its error. They are not used
+	// as the exit status of runvmtest or of a daemon job.
+	exitQEMUTimeout    = 124
+	exitInactivity     = 125
+	exitPanicDetected  = 126
+	serialLogTailLines = 50
+)
+
+// panicMarkers are lines that indicate the kernel under test has crashed
+// badly enough that waiting for it to exit on its own is pointless.
+var panicMarkers = regexp.MustCompile(`(?i)(kernel panic|Oops:|KASAN:|Call Trace:)`)
+
+// guardedRun execs cmd with the QEMU-lifecycle guardrails enabled by
+// -qemu-timeout, -after-start-timeout, and -panic-detect: a hard wall-clock
+// timeout, a post-boot inactivity timeout, and panic/oops/KASAN detection,
+// all while teeing the command's combined output into tmpDir/serial.log.
+//
+// If none of the three flags are set, it just runs cmd with no wrapping.
+func guardedRun(ctx context.Context, cmd *exec.Cmd, tmpDir string, keep bool) error {
+	if *qemuTimeout == 0 && *afterStartTimeout == 0 && !*panicDetect {
+		return cmd.Run()
+	}
+
+	logPath := filepath.Join(tmpDir, "serial.log")
+	logFile, err := os.Create(logPath)
+	if err != nil {
+		return fmt.Errorf("unable to create serial log: %w", err)
+	}
+	defer logFile.Close()
+	if keep {
+		defer fmt.Printf("serial log kept at %s\n", logPath)
+	}
+
+	// Tee into whatever the caller already set as cmd.Stdout (os.Stdout for
+	// a local invocation, a streamWriter back to a daemon client, etc.)
+	// instead of hardcoding os.Stdout, so guardedRun works for callers that
+	// don't want output on the guardedRun-calling process's own console.
+	out := cmd.Stdout
+	if out == nil {
+		out = io.Discard
+	}
+
+	pr, pw := io.Pipe()
+	cmd.Stdout = io.MultiWriter(pw, logFile, out)
+	cmd.Stderr = cmd.Stdout
+
+	if *qemuTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *qemuTimeout)
+		defer cancel()
+	}
+
+	activity := make(chan struct{}, 1)
+	killReason := make(chan int, 1)
+
+	if err := cmd.Start(); err != nil {
+		pw.Close()
+		return fmt.Errorf("failed to start: %w", err)
+	}
+
+	scanDone := make(chan struct{})
+	go func() {
+		defer close(scanDone)
+		scanner := bufio.NewScanner(pr)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			line := scanner.Text()
+			select {
+			case activity <- struct{}{}:
+			default:
+			}
+			if *panicDetect && panicMarkers.MatchString(line) {
+				select {
+				case killReason <- exitPanicDetected:
+				default:
+				}
+			}
+		}
+	}()
+
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+
+	var inactivity <-chan time.Time
+	var inactivityTimer *time.Timer
+	if *afterStartTimeout > 0 {
+		inactivityTimer = time.NewTimer(*afterStartTimeout)
+		defer inactivityTimer.Stop()
+		inactivity = inactivityTimer.C
+	}
+
+	var killedAs int
+	for {
+		select {
+		case err := <-done:
+			pw.Close()
+			<-scanDone
+			if killedAs != 0 {
+				printTail(logPath)
+				return fmt.Errorf("killed (%s): %w", exitReason(killedAs), err)
+			}
+			return err
+
+		case reason := <-killReason:
+			killedAs = reason
+			cmd.Process.Kill()
+
+		case <-activity:
+			// Real output arrived; fall through to reset the inactivity
+			// timer below instead of letting it fire mid-boot-noise.
+
+		case <-inactivity:
+			killedAs = exitInactivity
+			cmd.Process.Kill()
+
+		case <-ctx.Done():
+			killedAs = exitQEMUTimeout
+			cmd.Process.Kill()
+		}
+
+		if inactivityTimer != nil {
+			if !inactivityTimer.Stop() {
+				select {
+				case <-inactivityTimer.C:
+				default:
+				}
+			}
+			inactivityTimer.Reset(*afterStartTimeout)
+		}
+	}
+}
+
+func exitReason(code int) string {
+	switch code {
+	case exitQEMUTimeout:
+		return "qemu-timeout"
+	case exitInactivity:
+		return "after-start-timeout"
+	case exitPanicDetected:
+		return "panic-detect"
+	default:
+		return "unknown"
+	}
+}
+
+// printTail prints the last serialLogTailLines lines of the serial log to
+// help diagnose why the command was killed.
+func printTail(logPath string) {
+	data, err := os.ReadFile(logPath)
+	if err != nil {
+		return
+	}
+	lines := strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+	if len(lines) > serialLogTailLines {
+		lines = lines[len(lines)-serialLogTailLines:]
+	}
+	fmt.Fprintf(os.Stderr, "\n--- last %d lines of serial.log ---\n", len(lines))
+	for _, l := range lines {
+		fmt.Fprintln(os.Stderr, l)
+	}
+}
@@ -0,0 +1,120 @@
+// Copyright 2023 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// withGuardrails sets the guardedRun flag vars for the duration of a test
+// and restores their previous values afterwards, since they're shared
+// package-level flag.Value pointers rather than arguments.
+func withGuardrails(t *testing.T, qemu, afterStart time.Duration, panic bool) {
+	t.Helper()
+	prevQemu, prevAfterStart, prevPanic := *qemuTimeout, *afterStartTimeout, *panicDetect
+	*qemuTimeout, *afterStartTimeout, *panicDetect = qemu, afterStart, panic
+	t.Cleanup(func() {
+		*qemuTimeout, *afterStartTimeout, *panicDetect = prevQemu, prevAfterStart, prevPanic
+	})
+}
+
+func TestExitReason(t *testing.T) {
+	cases := map[int]string{
+		exitQEMUTimeout:   "qemu-timeout",
+		exitInactivity:    "after-start-timeout",
+		exitPanicDetected: "panic-detect",
+		0:                 "unknown",
+	}
+	for code, want := range cases {
+		if got := exitReason(code); got != want {
+			t.Errorf("exitReason(%d) = %q, want %q", code, got, want)
+		}
+	}
+}
+
+func TestPrintTailTruncatesToLastLines(t *testing.T) {
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, "serial.log")
+
+	var lines []string
+	for i := 0; i < serialLogTailLines+10; i++ {
+		lines = append(lines, "line")
+	}
+	if err := os.WriteFile(logPath, []byte(strings.Join(lines, "\n")+"\n"), 0o644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("Pipe: %v", err)
+	}
+	stderr := os.Stderr
+	os.Stderr = w
+	printTail(logPath)
+	os.Stderr = stderr
+	w.Close()
+
+	buf := make([]byte, 64*1024)
+	n, _ := r.Read(buf)
+	out := string(buf[:n])
+
+	if !strings.Contains(out, "last 50 lines") {
+		t.Errorf("printTail output missing tail-count header: %q", out)
+	}
+}
+
+func TestGuardedRunKillsOnInactivity(t *testing.T) {
+	withGuardrails(t, 0, 30*time.Millisecond, false)
+
+	cmd := exec.CommandContext(context.Background(), "sh", "-c", "echo booted; sleep 1")
+	err := guardedRun(context.Background(), cmd, t.TempDir(), false)
+	if err == nil || !strings.Contains(err.Error(), "after-start-timeout") {
+		t.Errorf("guardedRun = %v, want an after-start-timeout kill", err)
+	}
+}
+
+func TestGuardedRunResetsInactivityTimerOnOutput(t *testing.T) {
+	withGuardrails(t, 0, 60*time.Millisecond, false)
+
+	// Each echo resets the inactivity timer; if it didn't, the 40ms sleeps
+	// between them would still outrun a single 60ms timer that only ever
+	// started once.
+	cmd := exec.CommandContext(context.Background(), "sh", "-c",
+		"echo one; sleep 0.04; echo two; sleep 0.04; echo three; sleep 0.04; echo four")
+	if err := guardedRun(context.Background(), cmd, t.TempDir(), false); err != nil {
+		t.Errorf("guardedRun killed a command whose output kept resetting the inactivity timer: %v", err)
+	}
+}
+
+func TestGuardedRunKillsOnPanicDetect(t *testing.T) {
+	withGuardrails(t, 0, 0, true)
+
+	cmd := exec.CommandContext(context.Background(), "sh", "-c",
+		"echo Kernel panic - not syncing: test; sleep 1")
+	start := time.Now()
+	err := guardedRun(context.Background(), cmd, t.TempDir(), false)
+	if err == nil || !strings.Contains(err.Error(), "panic-detect") {
+		t.Errorf("guardedRun = %v, want a panic-detect kill", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("guardedRun took %s, want it to kill well before the command's own 1s sleep", elapsed)
+	}
+}
+
+func TestGuardedRunKillsOnQEMUTimeout(t *testing.T) {
+	withGuardrails(t, 30*time.Millisecond, 0, false)
+
+	cmd := exec.CommandContext(context.Background(), "sh", "-c", "sleep 1")
+	err := guardedRun(context.Background(), cmd, t.TempDir(), false)
+	if err == nil || !strings.Contains(err.Error(), "qemu-timeout") {
+		t.Errorf("guardedRun = %v, want a qemu-timeout kill", err)
+	}
+}